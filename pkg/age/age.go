@@ -118,18 +118,16 @@ RecipientsLoop:
 			return nil, errors.New("an scrypt recipient must be the only one")
 		}
 		for _, i := range identities {
-			if i.Type() != r.Type {
-				continue
-			}
-
-			if i, ok := i.(IdentityMatcher); ok {
-				err := i.Match(r)
-				if err != nil {
-					if err == ErrIncorrectIdentity {
-						continue
-					}
-					return nil, err
+			m, ok := i.(IdentityMatcher)
+			if !ok {
+				if i.Type() != r.Type {
+					continue
 				}
+			} else if err := m.Match(r); err != nil {
+				if err == ErrIncorrectIdentity {
+					continue
+				}
+				return nil, err
 			}
 
 			fileKey, err = i.Unwrap(r)
@@ -0,0 +1,53 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package age
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/aead/age/pkg/armor"
+)
+
+// EncryptArmored is like Encrypt, but wraps the age file in the ASCII
+// envelope implemented by package armor, so the resulting ciphertext can be
+// safely copy-pasted into media that doesn't tolerate arbitrary bytes, such
+// as email or chat.
+func EncryptArmored(dst io.Writer, recipients ...Recipient) (io.WriteCloser, error) {
+	aw := armor.NewWriter(dst)
+	w, err := Encrypt(aw, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	return &armoredWriteCloser{w: w, aw: aw}, nil
+}
+
+type armoredWriteCloser struct {
+	w  io.WriteCloser
+	aw io.WriteCloser
+}
+
+func (a *armoredWriteCloser) Write(p []byte) (int, error) { return a.w.Write(p) }
+
+func (a *armoredWriteCloser) Close() error {
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	return a.aw.Close()
+}
+
+// DecryptArmored is like Decrypt, but first checks whether src starts with
+// the armor.Header envelope and transparently strips it if present, so it
+// accepts both plain binary age files and armored ones.
+func DecryptArmored(src io.Reader, identities ...Identity) (io.Reader, error) {
+	br := bufio.NewReader(src)
+	peek, _ := br.Peek(len(armor.Header))
+	if string(peek) == armor.Header {
+		return Decrypt(armor.NewReader(br), identities...)
+	}
+	return Decrypt(br, identities...)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package age
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestEncryptArmoredRoundTrip(t *testing.T) {
+	id, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, err := EncryptArmored(&buf, id.Recipient())
+	if err != nil {
+		t.Fatalf("EncryptArmored: %v", err)
+	}
+	plaintext := []byte("the armor round trip still needs to decrypt correctly")
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := DecryptArmored(bytes.NewReader(buf.Bytes()), id)
+	if err != nil {
+		t.Fatalf("DecryptArmored: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("DecryptArmored returned %q, want %q", got, plaintext)
+	}
+}
+
+func TestDecryptArmoredAcceptsPlainBinary(t *testing.T) {
+	id, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, err := Encrypt(&buf, id.Recipient())
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	plaintext := []byte("not armored at all")
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := DecryptArmored(bytes.NewReader(buf.Bytes()), id)
+	if err != nil {
+		t.Fatalf("DecryptArmored: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("DecryptArmored returned %q, want %q", got, plaintext)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package age
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MarshalEncryptedIdentity writes i's bech32 "AGE-SECRET-KEY-1..." encoding
+// to w as a complete age file, encrypted to a ScryptRecipient derived from
+// password, so the identity can be stored on disk protected at rest.
+func MarshalEncryptedIdentity(i *X25519Identity, password string, w io.Writer) error {
+	r, err := NewScryptRecipient(password)
+	if err != nil {
+		return err
+	}
+
+	enc, err := Encrypt(w, r)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt identity: %v", err)
+	}
+	if _, err := io.WriteString(enc, i.String()+"\n"); err != nil {
+		return fmt.Errorf("failed to encrypt identity: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt identity: %v", err)
+	}
+	return nil
+}
+
+// ParseEncryptedIdentity reads an age file produced by
+// MarshalEncryptedIdentity from r, decrypts it with password, and parses
+// the resulting bech32 string into an X25519Identity.
+func ParseEncryptedIdentity(r io.Reader, password string) (*X25519Identity, error) {
+	id, err := NewScryptIdentity(password)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := Decrypt(r, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt identity: %v", err)
+	}
+	contents, err := io.ReadAll(dec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt identity: %v", err)
+	}
+
+	return ParseX25519Identity(strings.TrimSpace(string(contents)))
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package age
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedIdentityRoundTrip(t *testing.T) {
+	id, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := MarshalEncryptedIdentity(id, "hunter2", &buf); err != nil {
+		t.Fatalf("MarshalEncryptedIdentity: %v", err)
+	}
+
+	got, err := ParseEncryptedIdentity(bytes.NewReader(buf.Bytes()), "hunter2")
+	if err != nil {
+		t.Fatalf("ParseEncryptedIdentity: %v", err)
+	}
+	if got.String() != id.String() {
+		t.Errorf("ParseEncryptedIdentity returned %q, want %q", got.String(), id.String())
+	}
+}
+
+func TestEncryptedIdentityWrongPassword(t *testing.T) {
+	id, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := MarshalEncryptedIdentity(id, "hunter2", &buf); err != nil {
+		t.Fatalf("MarshalEncryptedIdentity: %v", err)
+	}
+
+	if _, err := ParseEncryptedIdentity(bytes.NewReader(buf.Bytes()), "wrong"); err == nil {
+		t.Fatal("ParseEncryptedIdentity with the wrong password succeeded, want an error")
+	}
+}
+
+func TestEncryptedIdentityMalformedInput(t *testing.T) {
+	if _, err := ParseEncryptedIdentity(strings.NewReader("not an age file"), "hunter2"); err == nil {
+		t.Fatal("ParseEncryptedIdentity with malformed input succeeded, want an error")
+	}
+}
+
+func TestMarshalEncryptedIdentityEmptyPassword(t *testing.T) {
+	id, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := MarshalEncryptedIdentity(id, "", &buf); err == nil {
+		t.Fatal("MarshalEncryptedIdentity with an empty password succeeded, want an error")
+	}
+}
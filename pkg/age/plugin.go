@@ -0,0 +1,125 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package age
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aead/age/pkg/bech32"
+)
+
+// Plugin-backed recipients and identities are implemented out of tree, in
+// package github.com/aead/age/pkg/plugin, to avoid this package depending on
+// os/exec and the plugin wire protocol. Package plugin registers its
+// constructors here through SetPluginFactories so that ParseRecipient and
+// ParseIdentity can delegate to it without an import cycle.
+var (
+	pluginRecipientFactory func(name, s string) (Recipient, error)
+	pluginIdentityFactory  func(name, s string) (Identity, error)
+)
+
+// registeredPlugins is the set of plugin names enabled with RegisterPlugin.
+var registeredPlugins = map[string]bool{}
+
+// RegisterPlugin enables ParseRecipient and ParseIdentity to recognize
+// bech32 strings tagged for the named plugin, such as "age1yubikey1..." or
+// "AGE-PLUGIN-YUBIKEY-1...", and hand them off to the age-plugin-<name>
+// binary on $PATH. Importing github.com/aead/age/pkg/plugin and calling
+// RegisterPlugin("yubikey") is what makes age.Encrypt and age.Decrypt able
+// to use such a plugin.
+func RegisterPlugin(name string) {
+	registeredPlugins[name] = true
+}
+
+// SetPluginFactories wires package plugin's Recipient and Identity
+// constructors into this package. It's called from plugin's init and is not
+// meant to be called directly by other code.
+func SetPluginFactories(
+	newRecipient func(name, s string) (Recipient, error),
+	newIdentity func(name, s string) (Identity, error),
+) {
+	pluginRecipientFactory = newRecipient
+	pluginIdentityFactory = newIdentity
+}
+
+// pluginName returns the plugin name embedded in a bech32 human-readable
+// part of the form "age1name" or "AGE-PLUGIN-NAME-", as returned by
+// bech32.Decode for a plugin recipient or identity respectively.
+func pluginName(hrp string) (name string, ok bool) {
+	switch {
+	case strings.HasPrefix(hrp, "age1") && len(hrp) > len("age1"):
+		return hrp[len("age1"):], true
+	case strings.HasPrefix(hrp, "AGE-PLUGIN-") && strings.HasSuffix(hrp, "-"):
+		name = strings.TrimSuffix(strings.TrimPrefix(hrp, "AGE-PLUGIN-"), "-")
+		if name == "" {
+			return "", false
+		}
+		return strings.ToLower(name), true
+	}
+	return "", false
+}
+
+// ParseRecipient parses a recipient encoded as a string: an "age1..."
+// X25519 public key, an "ssh-ed25519"/"ssh-rsa" authorized_keys line, or,
+// provided the relevant plugin was enabled with RegisterPlugin, a plugin
+// recipient such as "age1yubikey1...".
+func ParseRecipient(s string) (Recipient, error) {
+	if strings.HasPrefix(s, "ssh-ed25519 ") || strings.HasPrefix(s, "ssh-rsa ") {
+		return ParseSSHRecipient(s)
+	}
+
+	hrp, data, err := bech32.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed recipient %q: %v", s, err)
+	}
+	if name, ok := pluginName(hrp); ok {
+		if !registeredPlugins[name] {
+			return nil, fmt.Errorf("recipient %q requires plugin %q, which was not registered with RegisterPlugin", s, name)
+		}
+		if pluginRecipientFactory == nil {
+			return nil, fmt.Errorf("recipient %q requires plugin %q, but package plugin was not imported", s, name)
+		}
+		return pluginRecipientFactory(name, s)
+	}
+	if hrp != "age" {
+		return nil, fmt.Errorf("malformed recipient %q: invalid type %q", s, hrp)
+	}
+	r, err := NewX25519Recipient(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed recipient %q: %v", s, err)
+	}
+	return r, nil
+}
+
+// ParseIdentity parses an identity encoded as a string: an
+// "AGE-SECRET-KEY-1..." X25519 secret key, or, provided the relevant plugin
+// was enabled with RegisterPlugin, a plugin identity such as
+// "AGE-PLUGIN-YUBIKEY-1...".
+func ParseIdentity(s string) (Identity, error) {
+	hrp, data, err := bech32.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed secret key %q: %v", s, err)
+	}
+	if name, ok := pluginName(hrp); ok {
+		if !registeredPlugins[name] {
+			return nil, fmt.Errorf("identity %q requires plugin %q, which was not registered with RegisterPlugin", s, name)
+		}
+		if pluginIdentityFactory == nil {
+			return nil, fmt.Errorf("identity %q requires plugin %q, but package plugin was not imported", s, name)
+		}
+		return pluginIdentityFactory(name, s)
+	}
+	if hrp != "AGE-SECRET-KEY-" {
+		return nil, fmt.Errorf("malformed secret key %q: invalid type %q", s, hrp)
+	}
+	i, err := NewX25519Identity(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed secret key %q: %v", s, err)
+	}
+	return i, nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package age
+
+import "testing"
+
+func TestPluginName(t *testing.T) {
+	cases := []struct {
+		hrp  string
+		name string
+		ok   bool
+	}{
+		{"age", "", false},
+		{"age1", "", false},
+		{"age1yubikey", "yubikey", true},
+		{"AGE-SECRET-KEY-", "", false},
+		{"AGE-PLUGIN-", "", false},
+		{"AGE-PLUGIN-YUBIKEY", "", false}, // missing the trailing "-"
+		{"AGE-PLUGIN-YUBIKEY-", "yubikey", true},
+	}
+	for _, c := range cases {
+		name, ok := pluginName(c.hrp)
+		if ok != c.ok || name != c.name {
+			t.Errorf("pluginName(%q) = (%q, %v), want (%q, %v)", c.hrp, name, ok, c.name, c.ok)
+		}
+	}
+}
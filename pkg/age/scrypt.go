@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/aead/age/pkg/format"
 	"golang.org/x/crypto/chacha20poly1305"
@@ -42,8 +43,7 @@ func NewScryptRecipient(password string) (*ScryptRecipient, error) {
 		return nil, errors.New("passphrase can't be empty")
 	}
 	r := &ScryptRecipient{
-		password: []byte(password),
-		// TODO: automatically scale this to 1s (with a min) in the CLI.
+		password:   []byte(password),
 		workFactor: 18, // 1s on a modern machine
 	}
 	return r, nil
@@ -60,6 +60,68 @@ func (r *ScryptRecipient) SetWorkFactor(logN int) {
 	r.workFactor = logN
 }
 
+// SetTargetDuration calibrates the scrypt work factor so that deriving a key
+// takes about target on the machine SetTargetDuration runs on, and sets it
+// with SetWorkFactor. It must be called before Wrap.
+//
+// Callers that want encryption cost to track the speed of the machine
+// running them, rather than a hardcoded constant, should call this instead
+// of SetWorkFactor.
+func (r *ScryptRecipient) SetTargetDuration(target time.Duration) {
+	r.SetWorkFactor(CalibrateScryptWorkFactor(target, 10))
+}
+
+// maxCalibrationLogN bounds how far CalibrateScryptWorkFactor will probe
+// upward, independent of target. scrypt.Key's memory cost is roughly
+// 128*r*N bytes (r=8, N=1<<logN): at logN=27 that's already 128GiB, and at
+// logN=30 it's over 1TiB. A caller passing a large target (e.g. time.Minute
+// or time.Hour, to get extra-strong passphrase protection on an automated
+// system) must not be able to drive the benchmark loop that far on the
+// strength of target alone — it would almost certainly get the process
+// OOM-killed before a single scryptBenchmark call returns. 20 keeps a
+// single benchmark's memory use at about 1GiB.
+const maxCalibrationLogN = 20
+
+// CalibrateScryptWorkFactor benchmarks scrypt.Key with an increasing work
+// factor, starting at minLogN, and returns the largest logN whose derivation
+// time stays under target. The result is never lower than 18, which this
+// package considers the minimum safe work factor regardless of how fast the
+// machine is, and never higher than maxCalibrationLogN, regardless of target.
+func CalibrateScryptWorkFactor(target time.Duration, minLogN int) int {
+	const floor = 18
+
+	logN := minLogN
+	if logN < 1 {
+		logN = 1
+	}
+
+	best := floor
+	for logN <= maxCalibrationLogN {
+		if scryptBenchmark(logN) > target {
+			break
+		}
+		if logN > best {
+			best = logN
+		}
+		logN++
+	}
+	return best
+}
+
+// scryptBenchmark measures the wall-clock time scrypt.Key takes to derive a
+// key with the given work factor from a throwaway 16-byte password and salt.
+// It is a variable so that tests can replace it with a deterministic fake.
+var scryptBenchmark = func(logN int) time.Duration {
+	password := make([]byte, 16)
+	rand.Read(password)
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	start := time.Now()
+	scrypt.Key(password, salt, 1<<logN, 8, 1, chacha20poly1305.KeySize)
+	return time.Since(start)
+}
+
 func (r *ScryptRecipient) Wrap(fileKey []byte) (*format.Recipient, error) {
 	salt := make([]byte, 16)
 	if _, err := rand.Read(salt[:]); err != nil {
@@ -158,4 +220,4 @@ func (i *ScryptIdentity) Unwrap(block *format.Recipient) ([]byte, error) {
 		return nil, ErrIncorrectIdentity
 	}
 	return fileKey, nil
-}
\ No newline at end of file
+}
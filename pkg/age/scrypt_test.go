@@ -0,0 +1,75 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package age
+
+import (
+	"testing"
+	"time"
+)
+
+// withScryptBenchmark replaces scryptBenchmark with f for the duration of
+// the test.
+func withScryptBenchmark(t *testing.T, f func(logN int) time.Duration) {
+	t.Helper()
+	old := scryptBenchmark
+	t.Cleanup(func() { scryptBenchmark = old })
+	scryptBenchmark = f
+}
+
+func TestCalibrateScryptWorkFactorFloor(t *testing.T) {
+	// A benchmark that always exceeds the target must still never push the
+	// result below the safety floor of 18, however low minLogN is.
+	withScryptBenchmark(t, func(logN int) time.Duration { return time.Hour })
+
+	if got := CalibrateScryptWorkFactor(time.Second, 1); got != 18 {
+		t.Errorf("CalibrateScryptWorkFactor with a slow benchmark = %d, want floor of 18", got)
+	}
+}
+
+func TestCalibrateScryptWorkFactorMonotonic(t *testing.T) {
+	// A benchmark whose duration grows linearly with logN.
+	withScryptBenchmark(t, func(logN int) time.Duration {
+		return time.Duration(logN) * time.Millisecond
+	})
+
+	var prev int
+	for _, target := range []time.Duration{
+		20 * time.Millisecond,
+		25 * time.Millisecond,
+		28 * time.Millisecond,
+		29 * time.Millisecond,
+	} {
+		got := CalibrateScryptWorkFactor(target, 18)
+		if got < prev {
+			t.Fatalf("CalibrateScryptWorkFactor(%v, 18) = %d, lower than result %d for a smaller target", target, got, prev)
+		}
+		prev = got
+	}
+}
+
+func TestCalibrateScryptWorkFactorInstantBenchmarkSaturatesAtCeiling(t *testing.T) {
+	// An unrealistically fast benchmark should saturate at the calibration
+	// ceiling, not get stuck at the floor.
+	withScryptBenchmark(t, func(logN int) time.Duration { return 0 })
+
+	if got := CalibrateScryptWorkFactor(time.Second, 5); got != maxCalibrationLogN {
+		t.Errorf("CalibrateScryptWorkFactor with an instant benchmark = %d, want %d", got, maxCalibrationLogN)
+	}
+}
+
+func TestCalibrateScryptWorkFactorNeverExceedsCeilingRegardlessOfTarget(t *testing.T) {
+	// A benchmark that is always well under target (e.g. because the caller
+	// asked for a huge target, such as time.Hour, to get extra-strong
+	// passphrase protection) must not drive the loop past the calibration
+	// ceiling: target alone must never be trusted to bound the search, since
+	// scrypt.Key's memory cost grows exponentially with logN.
+	withScryptBenchmark(t, func(logN int) time.Duration { return time.Microsecond })
+
+	if got := CalibrateScryptWorkFactor(time.Hour, 18); got != maxCalibrationLogN {
+		t.Errorf("CalibrateScryptWorkFactor(time.Hour, 18) = %d, want ceiling of %d", got, maxCalibrationLogN)
+	}
+}
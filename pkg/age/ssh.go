@@ -0,0 +1,405 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package age
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/aead/age/pkg/format"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshEd25519Label = "age-encryption.org/v1/ssh-ed25519"
+	sshRSALabel     = "age-encryption.org/v1/ssh-rsa"
+)
+
+// sshFingerprint returns the first 4 bytes of the SHA-256 hash of the SSH
+// wire encoding of pk, used to tag stanzas so an identity can cheaply tell
+// whether a recipient block was wrapped for it.
+func sshFingerprint(pk ssh.PublicKey) []byte {
+	h := sha256.Sum256(pk.Marshal())
+	return h[:4]
+}
+
+// SSHEd25519Recipient is an age recipient backed by an existing ssh-ed25519
+// public key, such as one found in ~/.ssh/authorized_keys or on GitHub.
+type SSHEd25519Recipient struct {
+	sshKey      ed25519.PublicKey
+	fingerprint []byte
+}
+
+var _ Recipient = &SSHEd25519Recipient{}
+
+func (*SSHEd25519Recipient) Type() string { return "ssh-ed25519" }
+
+// NewSSHEd25519Recipient returns a new SSHEd25519Recipient from an
+// ssh.PublicKey of type "ssh-ed25519".
+func NewSSHEd25519Recipient(pk ssh.PublicKey) (*SSHEd25519Recipient, error) {
+	if pk.Type() != "ssh-ed25519" {
+		return nil, fmt.Errorf("SSH public key is not an Ed25519 key: %q", pk.Type())
+	}
+	ck, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, errors.New("unexpected ssh.PublicKey implementation")
+	}
+	edKey, ok := ck.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("unexpected Ed25519 public key implementation")
+	}
+	return &SSHEd25519Recipient{
+		sshKey:      edKey,
+		fingerprint: sshFingerprint(pk),
+	}, nil
+}
+
+func (r *SSHEd25519Recipient) Wrap(fileKey []byte) (*format.Recipient, error) {
+	theirPublicKey, err := ed25519PublicKeyToCurve25519(r.sshKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH recipient: %v", err)
+	}
+
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, err
+	}
+	ourPublicKey, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := curve25519.X25519(ephemeral, theirPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &format.Recipient{
+		Type: "ssh-ed25519",
+		Args: []string{format.EncodeToString(r.fingerprint), format.EncodeToString(ourPublicKey)},
+	}
+
+	salt := make([]byte, 0, len(ourPublicKey)+len(r.fingerprint))
+	salt = append(salt, ourPublicKey...)
+	salt = append(salt, r.fingerprint...)
+	h := hkdf.New(sha256.New, sharedSecret, salt, []byte(sshEd25519Label))
+	wrappingKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(h, wrappingKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := aeadEncrypt(wrappingKey, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	l.Body = wrappedKey
+
+	return l, nil
+}
+
+// SSHEd25519Identity is an age identity backed by an existing ssh-ed25519
+// private key, such as ~/.ssh/id_ed25519.
+type SSHEd25519Identity struct {
+	secretKey   ed25519.PrivateKey
+	sshKey      ed25519.PublicKey
+	fingerprint []byte
+}
+
+var _ IdentityMatcher = &SSHEd25519Identity{}
+
+func (*SSHEd25519Identity) Type() string { return "ssh-ed25519" }
+
+// NewSSHEd25519Identity returns a new SSHEd25519Identity from an
+// ed25519.PrivateKey, as produced by parsing an OpenSSH private key.
+func NewSSHEd25519Identity(secretKey ed25519.PrivateKey) (*SSHEd25519Identity, error) {
+	pk, err := ssh.NewPublicKey(secretKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH identity: %v", err)
+	}
+	return &SSHEd25519Identity{
+		secretKey:   secretKey,
+		sshKey:      secretKey.Public().(ed25519.PublicKey),
+		fingerprint: sshFingerprint(pk),
+	}, nil
+}
+
+func (i *SSHEd25519Identity) Match(block *format.Recipient) error {
+	if block.Type != "ssh-ed25519" {
+		return ErrIncorrectIdentity
+	}
+	if len(block.Args) != 2 {
+		return errors.New("invalid ssh-ed25519 recipient block")
+	}
+	tag, err := format.DecodeString(block.Args[0])
+	if err != nil || len(tag) != 4 {
+		return errors.New("invalid ssh-ed25519 recipient tag")
+	}
+	if !bytes.Equal(tag, i.fingerprint) {
+		return ErrIncorrectIdentity
+	}
+	return nil
+}
+
+func (i *SSHEd25519Identity) Unwrap(block *format.Recipient) ([]byte, error) {
+	if err := i.Match(block); err != nil {
+		return nil, err
+	}
+
+	ourSecretKey, err := ed25519PrivateKeyToCurve25519(i.secretKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH identity: %v", err)
+	}
+
+	theirPublicKey, err := format.DecodeString(block.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh-ed25519 ephemeral key: %v", err)
+	}
+	if len(theirPublicKey) != curve25519.PointSize {
+		return nil, errors.New("invalid ssh-ed25519 recipient block")
+	}
+
+	sharedSecret, err := curve25519.X25519(ourSecretKey, theirPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh-ed25519 recipient: %v", err)
+	}
+
+	salt := make([]byte, 0, len(theirPublicKey)+len(i.fingerprint))
+	salt = append(salt, theirPublicKey...)
+	salt = append(salt, i.fingerprint...)
+	h := hkdf.New(sha256.New, sharedSecret, salt, []byte(sshEd25519Label))
+	wrappingKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(h, wrappingKey); err != nil {
+		return nil, err
+	}
+
+	fileKey, err := aeadDecrypt(wrappingKey, block.Body)
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+	return fileKey, nil
+}
+
+// SSHRSARecipient is an age recipient backed by an existing ssh-rsa public
+// key, such as one found in ~/.ssh/authorized_keys or on GitHub.
+type SSHRSARecipient struct {
+	sshKey      *rsa.PublicKey
+	fingerprint []byte
+}
+
+var _ Recipient = &SSHRSARecipient{}
+
+func (*SSHRSARecipient) Type() string { return "ssh-rsa" }
+
+// NewSSHRSARecipient returns a new SSHRSARecipient from an ssh.PublicKey of
+// type "ssh-rsa".
+func NewSSHRSARecipient(pk ssh.PublicKey) (*SSHRSARecipient, error) {
+	if pk.Type() != "ssh-rsa" {
+		return nil, fmt.Errorf("SSH public key is not an RSA key: %q", pk.Type())
+	}
+	ck, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, errors.New("unexpected ssh.PublicKey implementation")
+	}
+	rsaKey, ok := ck.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("unexpected RSA public key implementation")
+	}
+	return &SSHRSARecipient{
+		sshKey:      rsaKey,
+		fingerprint: sshFingerprint(pk),
+	}, nil
+}
+
+func (r *SSHRSARecipient) Wrap(fileKey []byte) (*format.Recipient, error) {
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r.sshKey, fileKey, []byte(sshRSALabel))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap file key: %v", err)
+	}
+
+	l := &format.Recipient{
+		Type: "ssh-rsa",
+		Args: []string{format.EncodeToString(r.fingerprint)},
+		Body: wrappedKey,
+	}
+	return l, nil
+}
+
+// SSHRSAIdentity is an age identity backed by an existing ssh-rsa private
+// key, such as ~/.ssh/id_rsa.
+type SSHRSAIdentity struct {
+	secretKey   *rsa.PrivateKey
+	fingerprint []byte
+}
+
+var _ IdentityMatcher = &SSHRSAIdentity{}
+
+func (*SSHRSAIdentity) Type() string { return "ssh-rsa" }
+
+// NewSSHRSAIdentity returns a new SSHRSAIdentity from an rsa.PrivateKey, as
+// produced by parsing an OpenSSH private key.
+func NewSSHRSAIdentity(secretKey *rsa.PrivateKey) (*SSHRSAIdentity, error) {
+	pk, err := ssh.NewPublicKey(secretKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH identity: %v", err)
+	}
+	return &SSHRSAIdentity{
+		secretKey:   secretKey,
+		fingerprint: sshFingerprint(pk),
+	}, nil
+}
+
+func (i *SSHRSAIdentity) Match(block *format.Recipient) error {
+	if block.Type != "ssh-rsa" {
+		return ErrIncorrectIdentity
+	}
+	if len(block.Args) != 1 {
+		return errors.New("invalid ssh-rsa recipient block")
+	}
+	tag, err := format.DecodeString(block.Args[0])
+	if err != nil || len(tag) != 4 {
+		return errors.New("invalid ssh-rsa recipient tag")
+	}
+	if !bytes.Equal(tag, i.fingerprint) {
+		return ErrIncorrectIdentity
+	}
+	return nil
+}
+
+func (i *SSHRSAIdentity) Unwrap(block *format.Recipient) ([]byte, error) {
+	if err := i.Match(block); err != nil {
+		return nil, err
+	}
+
+	fileKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, i.secretKey, block.Body, []byte(sshRSALabel))
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+	return fileKey, nil
+}
+
+// ParseSSHRecipient returns a new Recipient from a single public key line, in
+// the authorized_keys format used by ssh-ed25519 and ssh-rsa keys, such as
+// the ones found in ~/.ssh/authorized_keys or published on GitHub.
+func ParseSSHRecipient(s string) (Recipient, error) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("malformed SSH recipient %q: %v", s, err)
+	}
+	switch pk.Type() {
+	case "ssh-ed25519":
+		return NewSSHEd25519Recipient(pk)
+	case "ssh-rsa":
+		return NewSSHRSARecipient(pk)
+	default:
+		return nil, fmt.Errorf("unsupported SSH key type %q", pk.Type())
+	}
+}
+
+// ParseSSHIdentity returns a new Identity from a PEM-encoded OpenSSH private
+// key, such as the contents of ~/.ssh/id_ed25519 or ~/.ssh/id_rsa.
+//
+// If the key is passphrase-protected, ParseSSHIdentity returns an error
+// wrapping ssh.PassphraseMissingError; callers should retry with
+// ParseEncryptedSSHIdentity.
+func ParseSSHIdentity(pemBytes []byte) (Identity, error) {
+	return parseSSHIdentity(pemBytes, nil)
+}
+
+// ParseEncryptedSSHIdentity returns a new Identity from a passphrase-protected
+// PEM-encoded OpenSSH private key, calling getPassphrase to obtain the
+// passphrase used to decrypt it.
+func ParseEncryptedSSHIdentity(pemBytes []byte, getPassphrase func() ([]byte, error)) (Identity, error) {
+	if getPassphrase == nil {
+		return nil, errors.New("getPassphrase can't be nil")
+	}
+	return parseSSHIdentity(pemBytes, getPassphrase)
+}
+
+func parseSSHIdentity(pemBytes []byte, getPassphrase func() ([]byte, error)) (Identity, error) {
+	k, err := ssh.ParseRawPrivateKey(pemBytes)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		if getPassphrase == nil {
+			return nil, fmt.Errorf("SSH key is encrypted: %w", err)
+		}
+		passphrase, perr := getPassphrase()
+		if perr != nil {
+			return nil, perr
+		}
+		k, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed SSH identity: %v", err)
+	}
+
+	switch k := k.(type) {
+	case *ed25519.PrivateKey:
+		return NewSSHEd25519Identity(*k)
+	case *rsa.PrivateKey:
+		return NewSSHRSAIdentity(k)
+	default:
+		return nil, fmt.Errorf("unsupported SSH key type %T", k)
+	}
+}
+
+// curve25519P is the order of the field underlying Curve25519, 2^255 - 19.
+var curve25519P, _ = new(big.Int).SetString("57896044618658097711785492504343953926634992332820282019728792003956564819949", 10)
+
+// ed25519PublicKeyToCurve25519 converts an Ed25519 public key, a point on the
+// twisted Edwards curve, to the birationally equivalent Curve25519 point, by
+// mapping the Edwards y-coordinate to the Montgomery u-coordinate with
+// u = (1 + y) / (1 - y).
+func ed25519PublicKeyToCurve25519(pk ed25519.PublicKey) ([]byte, error) {
+	if len(pk) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+
+	bigEndianY := make([]byte, ed25519.PublicKeySize)
+	for i, b := range pk {
+		bigEndianY[ed25519.PublicKeySize-i-1] = b
+	}
+	bigEndianY[0] &= 0b0111_1111 // clear the sign bit of the X coordinate
+
+	y := new(big.Int).SetBytes(bigEndianY)
+	denom := new(big.Int).Sub(big.NewInt(1), y)
+	denom.Mod(denom, curve25519P)
+	denom.ModInverse(denom, curve25519P)
+
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, denom)
+	u.Mod(u, curve25519P)
+
+	out := make([]byte, curve25519.PointSize)
+	uBytes := u.Bytes()
+	for i, b := range uBytes {
+		out[len(uBytes)-i-1] = b
+	}
+	return out, nil
+}
+
+// ed25519PrivateKeyToCurve25519 converts an Ed25519 private key to the
+// corresponding Curve25519 scalar, by taking the clamped first half of the
+// SHA-512 expansion of its seed, exactly as Ed25519 itself derives its
+// signing scalar.
+func ed25519PrivateKeyToCurve25519(pk ed25519.PrivateKey) ([]byte, error) {
+	digest := sha512.Sum512(pk.Seed())
+	out := make([]byte, curve25519.ScalarSize)
+	copy(out, digest[:curve25519.ScalarSize])
+	out[0] &= 248
+	out[31] &= 127
+	out[31] |= 64
+	return out, nil
+}
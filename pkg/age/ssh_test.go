@@ -0,0 +1,353 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package age
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHEd25519RoundTrip(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to build ssh.PublicKey: %v", err)
+	}
+
+	r, err := NewSSHEd25519Recipient(sshPub)
+	if err != nil {
+		t.Fatalf("NewSSHEd25519Recipient: %v", err)
+	}
+	i, err := NewSSHEd25519Identity(priv)
+	if err != nil {
+		t.Fatalf("NewSSHEd25519Identity: %v", err)
+	}
+
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		t.Fatalf("failed to generate file key: %v", err)
+	}
+
+	stanza, err := r.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	got, err := i.Unwrap(stanza)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if !bytes.Equal(got, fileKey) {
+		t.Errorf("Unwrap returned %x, want %x", got, fileKey)
+	}
+}
+
+func TestSSHEd25519WrongIdentity(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to build ssh.PublicKey: %v", err)
+	}
+	r, err := NewSSHEd25519Recipient(sshPub)
+	if err != nil {
+		t.Fatalf("NewSSHEd25519Recipient: %v", err)
+	}
+
+	_, wrongPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	wrongIdentity, err := NewSSHEd25519Identity(wrongPriv)
+	if err != nil {
+		t.Fatalf("NewSSHEd25519Identity: %v", err)
+	}
+
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		t.Fatalf("failed to generate file key: %v", err)
+	}
+	stanza, err := r.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	if _, err := wrongIdentity.Unwrap(stanza); err != ErrIncorrectIdentity {
+		t.Errorf("Unwrap with the wrong identity returned %v, want ErrIncorrectIdentity", err)
+	}
+}
+
+func TestSSHRSARoundTrip(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to build ssh.PublicKey: %v", err)
+	}
+
+	r, err := NewSSHRSARecipient(sshPub)
+	if err != nil {
+		t.Fatalf("NewSSHRSARecipient: %v", err)
+	}
+	i, err := NewSSHRSAIdentity(priv)
+	if err != nil {
+		t.Fatalf("NewSSHRSAIdentity: %v", err)
+	}
+
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		t.Fatalf("failed to generate file key: %v", err)
+	}
+
+	stanza, err := r.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	got, err := i.Unwrap(stanza)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if !bytes.Equal(got, fileKey) {
+		t.Errorf("Unwrap returned %x, want %x", got, fileKey)
+	}
+}
+
+func TestSSHRSAWrongIdentity(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to build ssh.PublicKey: %v", err)
+	}
+	r, err := NewSSHRSARecipient(sshPub)
+	if err != nil {
+		t.Fatalf("NewSSHRSARecipient: %v", err)
+	}
+
+	wrongPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	wrongIdentity, err := NewSSHRSAIdentity(wrongPriv)
+	if err != nil {
+		t.Fatalf("NewSSHRSAIdentity: %v", err)
+	}
+
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		t.Fatalf("failed to generate file key: %v", err)
+	}
+	stanza, err := r.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	if _, err := wrongIdentity.Unwrap(stanza); err != ErrIncorrectIdentity {
+		t.Errorf("Unwrap with the wrong identity returned %v, want ErrIncorrectIdentity", err)
+	}
+}
+
+func TestParseSSHRecipientAuthorizedKeysLine(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to build ssh.PublicKey: %v", err)
+	}
+	line := string(ssh.MarshalAuthorizedKey(sshPub)) // e.g. "ssh-ed25519 AAAA...\n"
+
+	r, err := ParseSSHRecipient(line)
+	if err != nil {
+		t.Fatalf("ParseSSHRecipient: %v", err)
+	}
+	if _, ok := r.(*SSHEd25519Recipient); !ok {
+		t.Fatalf("ParseSSHRecipient returned %T, want *SSHEd25519Recipient", r)
+	}
+}
+
+func TestParseSSHRecipientUnsupportedKeyType(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to build ssh.PublicKey: %v", err)
+	}
+	line := string(ssh.MarshalAuthorizedKey(sshPub))
+
+	if _, err := ParseSSHRecipient(line); err == nil {
+		t.Fatal("ParseSSHRecipient with an ecdsa-sha2-nistp256 key succeeded, want an error")
+	}
+}
+
+func TestParseSSHRecipientMalformed(t *testing.T) {
+	if _, err := ParseSSHRecipient("not an authorized_keys line"); err == nil {
+		t.Fatal("ParseSSHRecipient with malformed input succeeded, want an error")
+	}
+}
+
+func TestParseSSHIdentityEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey: %v", err)
+	}
+
+	got, err := ParseSSHIdentity(pem.EncodeToMemory(block))
+	if err != nil {
+		t.Fatalf("ParseSSHIdentity: %v", err)
+	}
+	identity, ok := got.(*SSHEd25519Identity)
+	if !ok {
+		t.Fatalf("ParseSSHIdentity returned %T, want *SSHEd25519Identity", got)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to build ssh.PublicKey: %v", err)
+	}
+	r, err := NewSSHEd25519Recipient(sshPub)
+	if err != nil {
+		t.Fatalf("NewSSHEd25519Recipient: %v", err)
+	}
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		t.Fatalf("failed to generate file key: %v", err)
+	}
+	stanza, err := r.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	gotKey, err := identity.Unwrap(stanza)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if !bytes.Equal(gotKey, fileKey) {
+		t.Errorf("Unwrap returned %x, want %x", gotKey, fileKey)
+	}
+}
+
+func TestParseSSHIdentityRSA(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey: %v", err)
+	}
+
+	got, err := ParseSSHIdentity(pem.EncodeToMemory(block))
+	if err != nil {
+		t.Fatalf("ParseSSHIdentity: %v", err)
+	}
+	if _, ok := got.(*SSHRSAIdentity); !ok {
+		t.Fatalf("ParseSSHIdentity returned %T, want *SSHRSAIdentity", got)
+	}
+}
+
+func TestParseSSHIdentityEncryptedWithoutPassphraseFails(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("MarshalPrivateKeyWithPassphrase: %v", err)
+	}
+
+	_, err = ParseSSHIdentity(pem.EncodeToMemory(block))
+	var passphraseErr *ssh.PassphraseMissingError
+	if !errors.As(err, &passphraseErr) {
+		t.Fatalf("ParseSSHIdentity on an encrypted key returned %v, want an error wrapping ssh.PassphraseMissingError", err)
+	}
+}
+
+func TestParseEncryptedSSHIdentityRoundTrip(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	passphrase := []byte("hunter2")
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", passphrase)
+	if err != nil {
+		t.Fatalf("MarshalPrivateKeyWithPassphrase: %v", err)
+	}
+	pemBytes := pem.EncodeToMemory(block)
+
+	got, err := ParseEncryptedSSHIdentity(pemBytes, func() ([]byte, error) { return passphrase, nil })
+	if err != nil {
+		t.Fatalf("ParseEncryptedSSHIdentity: %v", err)
+	}
+	identity, ok := got.(*SSHEd25519Identity)
+	if !ok {
+		t.Fatalf("ParseEncryptedSSHIdentity returned %T, want *SSHEd25519Identity", got)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to build ssh.PublicKey: %v", err)
+	}
+	r, err := NewSSHEd25519Recipient(sshPub)
+	if err != nil {
+		t.Fatalf("NewSSHEd25519Recipient: %v", err)
+	}
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		t.Fatalf("failed to generate file key: %v", err)
+	}
+	stanza, err := r.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	gotKey, err := identity.Unwrap(stanza)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if !bytes.Equal(gotKey, fileKey) {
+		t.Errorf("Unwrap returned %x, want %x", gotKey, fileKey)
+	}
+
+	if _, err := ParseEncryptedSSHIdentity(pemBytes, func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+		t.Fatal("ParseEncryptedSSHIdentity with the wrong passphrase succeeded, want an error")
+	}
+}
+
+func TestParseSSHIdentityMalformed(t *testing.T) {
+	if _, err := ParseSSHIdentity([]byte("not a PEM key")); err == nil {
+		t.Fatal("ParseSSHIdentity with malformed input succeeded, want an error")
+	}
+}
+
+func TestParseEncryptedSSHIdentityNilGetPassphrase(t *testing.T) {
+	if _, err := ParseEncryptedSSHIdentity([]byte("irrelevant"), nil); err == nil {
+		t.Fatal("ParseEncryptedSSHIdentity with a nil getPassphrase succeeded, want an error")
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// Package armor implements a strict, PEM-like ASCII envelope for age files,
+// so a binary age ciphertext can be safely copy-pasted into media that
+// doesn't tolerate arbitrary bytes, such as email or chat.
+package armor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Header and Footer delimit an armored age file, mirroring the PEM
+// convention, but naming the format explicitly instead of reusing "PEM".
+const (
+	Header = "-----BEGIN AGE ENCRYPTED FILE-----"
+	Footer = "-----END AGE ENCRYPTED FILE-----"
+)
+
+const lineWidth = 64
+
+// crc24Init and crc24Poly are the CRC-24 parameters used by the envelope's
+// trailing checksum line, the same ones OpenPGP ASCII armor uses (RFC 4880
+// §6.1), so existing CRC-24 implementations can double-check the output.
+const (
+	crc24Init = 0xB704CE
+	crc24Poly = 0x1864CFB
+)
+
+func updateCRC24(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
+
+// lineBreaker inserts a newline every width bytes written to dst.
+type lineBreaker struct {
+	dst   io.Writer
+	width int
+	buf   []byte
+}
+
+func (l *lineBreaker) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		n := l.width - len(l.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		l.buf = append(l.buf, p[:n]...)
+		p = p[n:]
+		if len(l.buf) == l.width {
+			if _, err := l.dst.Write(l.buf); err != nil {
+				return 0, err
+			}
+			if _, err := io.WriteString(l.dst, "\n"); err != nil {
+				return 0, err
+			}
+			l.buf = l.buf[:0]
+		}
+	}
+	return total, nil
+}
+
+func (l *lineBreaker) Close() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+	if _, err := l.dst.Write(l.buf); err != nil {
+		return err
+	}
+	_, err := io.WriteString(l.dst, "\n")
+	return err
+}
+
+// Writer wraps the bytes written to it in the age armor envelope and writes
+// them to the underlying io.Writer. The header is written lazily, on the
+// first Write or on Close if nothing was ever written, so an empty payload
+// still produces a well-formed envelope.
+type Writer struct {
+	dst     io.Writer
+	started bool
+	lb      *lineBreaker
+	b64     io.WriteCloser
+	crc     uint32
+}
+
+// NewWriter returns a Writer that armors everything written to it and
+// writes the result to dst. The caller must call Close when done for the
+// footer and checksum to be flushed.
+func NewWriter(dst io.Writer) io.WriteCloser {
+	return &Writer{dst: dst, crc: crc24Init}
+}
+
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	if _, err := io.WriteString(w.dst, Header+"\n\n"); err != nil {
+		return err
+	}
+	w.lb = &lineBreaker{dst: w.dst, width: lineWidth}
+	w.b64 = base64.NewEncoder(base64.StdEncoding, w.lb)
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	w.crc = updateCRC24(w.crc, p)
+	return w.b64.Write(p)
+}
+
+// Close flushes the remaining base64 data and writes the CRC-24 checksum
+// line and the footer.
+func (w *Writer) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if err := w.b64.Close(); err != nil {
+		return err
+	}
+	if err := w.lb.Close(); err != nil {
+		return err
+	}
+
+	sum := w.crc & 0xFFFFFF
+	sumBytes := []byte{byte(sum >> 16), byte(sum >> 8), byte(sum)}
+	if _, err := io.WriteString(w.dst, "="+base64.StdEncoding.EncodeToString(sumBytes)+"\n"); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.dst, Footer+"\n")
+	return err
+}
+
+// reader implements io.Reader over the decoded body of an armored file. It
+// reads and verifies the whole envelope up front, since the checksum can
+// only be checked once the full body is known.
+type reader struct {
+	body *bytes.Reader
+	err  error
+}
+
+// NewReader returns a Reader that reads the decoded, checksum-verified
+// payload of the age armor envelope in src.
+func NewReader(src io.Reader) io.Reader {
+	r := &reader{}
+	r.body, r.err = decodeEnvelope(src)
+	return r
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.body.Read(p)
+}
+
+func decodeEnvelope(src io.Reader) (*bytes.Reader, error) {
+	br := bufio.NewReader(src)
+
+	line, err := readLine(br)
+	if err != nil {
+		return nil, fmt.Errorf("armor: failed to read header: %v", err)
+	}
+	if line != Header {
+		return nil, errors.New("armor: invalid header")
+	}
+	if blank, err := readLine(br); err != nil || blank != "" {
+		return nil, errors.New("armor: malformed envelope")
+	}
+
+	var encoded strings.Builder
+	var checksum string
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return nil, errors.New("armor: truncated envelope")
+		}
+		if line == Footer {
+			break
+		}
+		if strings.HasPrefix(line, "=") {
+			checksum = strings.TrimPrefix(line, "=")
+			if line, err = readLine(br); err != nil || line != Footer {
+				return nil, errors.New("armor: malformed footer")
+			}
+			break
+		}
+		encoded.WriteString(line)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return nil, fmt.Errorf("armor: invalid base64 body: %v", err)
+	}
+
+	if checksum == "" {
+		return nil, errors.New("armor: missing CRC-24 checksum")
+	}
+	sumBytes, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil || len(sumBytes) != 3 {
+		return nil, errors.New("armor: invalid CRC-24 checksum")
+	}
+	want := uint32(sumBytes[0])<<16 | uint32(sumBytes[1])<<8 | uint32(sumBytes[2])
+	if got := updateCRC24(crc24Init, body); got != want {
+		return nil, errors.New("armor: CRC-24 checksum mismatch")
+	}
+
+	return bytes.NewReader(body), nil
+}
+
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package armor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+)
+
+func roundTrip(t *testing.T, payload []byte) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if _, err := w.Write(payload); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	got, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("round trip returned %x, want %x", got, payload)
+	}
+	return buf.String()
+}
+
+func TestRoundTripEmpty(t *testing.T) {
+	envelope := roundTrip(t, nil)
+	if !strings.HasPrefix(envelope, Header+"\n\n") {
+		t.Errorf("empty envelope missing header: %q", envelope)
+	}
+	if !strings.HasSuffix(envelope, Footer+"\n") {
+		t.Errorf("empty envelope missing footer: %q", envelope)
+	}
+}
+
+func TestRoundTripSingleLine(t *testing.T) {
+	roundTrip(t, []byte("hello age"))
+}
+
+func TestRoundTripMultiLine(t *testing.T) {
+	// lineWidth is the width, in base64 characters, of each line of the
+	// encoded body; a payload
+	// large enough to span several wrapped lines exercises lineBreaker's
+	// buffering across multiple Write calls.
+	payload := make([]byte, lineWidth*3+7)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatalf("failed to generate payload: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, chunk := range [][]byte{payload[:10], payload[10:100], payload[100:]} {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	body := strings.TrimSuffix(strings.TrimPrefix(buf.String(), Header+"\n\n"), Footer+"\n")
+	lines := strings.Split(strings.TrimRight(body, "\n"), "\n")
+	if got := len(lines[0]); got != lineWidth {
+		t.Errorf("first wrapped line has length %d, want %d", got, lineWidth)
+	}
+
+	got, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("round trip returned %x, want %x", got, payload)
+	}
+}
+
+func TestNewReaderInvalidHeader(t *testing.T) {
+	src := strings.NewReader("-----BEGIN SOMETHING ELSE-----\n\nAAAA\n" + Footer + "\n")
+	if _, err := io.ReadAll(NewReader(src)); err == nil {
+		t.Fatal("NewReader with an invalid header succeeded, want an error")
+	}
+}
+
+func TestNewReaderTruncated(t *testing.T) {
+	src := strings.NewReader(Header + "\n\nAAAA\n")
+	if _, err := io.ReadAll(NewReader(src)); err == nil {
+		t.Fatal("NewReader with a truncated envelope succeeded, want an error")
+	}
+}
+
+func TestNewReaderCorruptedChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if _, err := w.Write([]byte("hello age")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	corrupted := strings.Replace(buf.String(), "=", "=A", 1)
+	if _, err := io.ReadAll(NewReader(strings.NewReader(corrupted))); err == nil {
+		t.Fatal("NewReader with a corrupted checksum succeeded, want an error")
+	}
+}
+
+func TestNewReaderCorruptedBody(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if _, err := w.Write([]byte("hello age, a few more bytes to flip safely")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	for i, line := range lines {
+		if line != "" && !strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "=") {
+			flipped := byte('A')
+			if line[0] == flipped {
+				flipped = 'B'
+			}
+			lines[i] = string(flipped) + line[1:]
+			break
+		}
+	}
+	corrupted := strings.Join(lines, "\n")
+
+	if _, err := io.ReadAll(NewReader(strings.NewReader(corrupted))); err == nil {
+		t.Fatal("NewReader with a corrupted body succeeded, want a CRC-24 checksum mismatch error")
+	}
+}
+
+func TestNewReaderMissingChecksum(t *testing.T) {
+	src := strings.NewReader(Header + "\n\nAAAA\n" + Footer + "\n")
+	if _, err := io.ReadAll(NewReader(src)); err == nil {
+		t.Fatal("NewReader with no checksum line succeeded, want an error")
+	}
+}
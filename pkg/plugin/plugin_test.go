@@ -0,0 +1,237 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+//go:build !windows
+
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aead/age/pkg/age"
+	"github.com/aead/age/pkg/format"
+	"github.com/aead/age/pkg/ui"
+)
+
+// withFakePlugin writes script as an executable age-plugin-<name> and
+// prepends its directory to $PATH for the duration of the test, so
+// startSession can find it as if it were installed.
+func withFakePlugin(t *testing.T, name, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "age-plugin-"+name)
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("failed to write fake plugin %s: %v", path, err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// fakeUI is a ui.UI that records displayed messages and answers
+// RequestSecret with a fixed secret.
+type fakeUI struct {
+	messages []string
+	secret   string
+}
+
+var _ ui.UI = &fakeUI{}
+
+func (f *fakeUI) Display(message string) error {
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+func (f *fakeUI) RequestSecret(prompt string) (string, error) {
+	return f.secret, nil
+}
+
+func TestRecipientWrap(t *testing.T) {
+	const stanzaBody = "stanza-body"
+	script := "#!/bin/sh\n" +
+		"while IFS= read -r line; do\n" +
+		"  [ \"$line\" = \"-> done\" ] && break\n" +
+		"done\n" +
+		"printf -- '-> recipient-stanza 0 fake-stanza arg1 arg2\\n'\n" +
+		"printf '%s\\n' '" + format.EncodeToString([]byte(stanzaBody)) + "'\n" +
+		"printf -- '-> done\\n'\n"
+	withFakePlugin(t, "recipienttest", script)
+
+	r := NewRecipient("recipienttest", "dummy-recipient")
+	stanza, err := r.Wrap([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if stanza.Type != "fake-stanza" {
+		t.Errorf("stanza.Type = %q, want %q", stanza.Type, "fake-stanza")
+	}
+	if len(stanza.Args) != 2 || stanza.Args[0] != "arg1" || stanza.Args[1] != "arg2" {
+		t.Errorf("stanza.Args = %v, want [arg1 arg2]", stanza.Args)
+	}
+	if string(stanza.Body) != stanzaBody {
+		t.Errorf("stanza.Body = %q, want %q", stanza.Body, stanzaBody)
+	}
+}
+
+// TestSessionReadMessagesMultiStanza drives the session protocol directly,
+// below Recipient.Wrap, which rejects a batch with more than one
+// recipient-stanza: it covers readMessages parsing a batch of several
+// header/body blocks terminated by a single "-> done".
+func TestSessionReadMessagesMultiStanza(t *testing.T) {
+	script := "#!/bin/sh\n" +
+		"printf -- '-> recipient-stanza 0 type-a argA\\n'\n" +
+		"printf '%s\\n' '" + format.EncodeToString([]byte("bodyA")) + "'\n" +
+		"printf -- '-> recipient-stanza 1 type-b argB\\n'\n" +
+		"printf '%s\\n' '" + format.EncodeToString([]byte("bodyB")) + "'\n" +
+		"printf -- '-> done\\n'\n"
+	withFakePlugin(t, "batchtest", script)
+
+	sess, err := startSession("batchtest", "recipient-v1")
+	if err != nil {
+		t.Fatalf("startSession: %v", err)
+	}
+	defer sess.close()
+
+	msgs, err := sess.readMessages()
+	if err != nil {
+		t.Fatalf("readMessages: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("readMessages returned %d messages, want 2", len(msgs))
+	}
+	if msgs[0].command != "recipient-stanza" || len(msgs[0].args) < 2 || msgs[0].args[1] != "type-a" || string(msgs[0].body) != "bodyA" {
+		t.Errorf("msgs[0] = %+v", msgs[0])
+	}
+	if msgs[1].command != "recipient-stanza" || len(msgs[1].args) < 2 || msgs[1].args[1] != "type-b" || string(msgs[1].body) != "bodyB" {
+		t.Errorf("msgs[1] = %+v", msgs[1])
+	}
+}
+
+// TestRecipientWrapMultipleStanzasErrors checks that Wrap refuses to
+// silently drop extra recipient-stanza messages when a plugin replies with
+// more than one for a single wrap-file-key call.
+func TestRecipientWrapMultipleStanzasErrors(t *testing.T) {
+	script := "#!/bin/sh\n" +
+		"while IFS= read -r line; do\n" +
+		"  [ \"$line\" = \"-> done\" ] && break\n" +
+		"done\n" +
+		"printf -- '-> recipient-stanza 0 fake-stanza-a arg1\\n'\n" +
+		"printf '%s\\n' '" + format.EncodeToString([]byte("bodyA")) + "'\n" +
+		"printf -- '-> recipient-stanza 0 fake-stanza-b arg2\\n'\n" +
+		"printf '%s\\n' '" + format.EncodeToString([]byte("bodyB")) + "'\n" +
+		"printf -- '-> done\\n'\n"
+	withFakePlugin(t, "multistanzatest", script)
+
+	r := NewRecipient("multistanzatest", "dummy-recipient")
+	if _, err := r.Wrap([]byte("0123456789abcdef")); err == nil {
+		t.Fatal("Wrap against a plugin returning multiple recipient-stanza messages succeeded, want an error")
+	}
+}
+
+// TestIdentityUnwrapRequestSecret drives Identity.Unwrap against a fake
+// plugin that first asks the user to touch their token and enter a PIN,
+// exercising the request-secret/ok round trip, before returning the file
+// key in a second message batch.
+func TestIdentityUnwrapRequestSecret(t *testing.T) {
+	fileKey := []byte("0123456789abcdef")
+	fileKeyB64 := format.EncodeToString(fileKey)
+
+	script := "#!/bin/sh\n" +
+		"state=0\n" +
+		"while IFS= read -r line; do\n" +
+		"  if [ \"$line\" = \"-> done\" ]; then\n" +
+		"    state=$((state+1))\n" +
+		"    if [ \"$state\" -eq 1 ]; then\n" +
+		"      printf -- '-> msg please touch your token\\n'\n" +
+		"      printf -- '-> request-secret enter your PIN\\n'\n" +
+		"      printf -- '-> done\\n'\n" +
+		"    elif [ \"$state\" -eq 2 ]; then\n" +
+		"      printf -- '-> file-key\\n'\n" +
+		"      printf '%s\\n' '" + fileKeyB64 + "'\n" +
+		"      printf -- '-> done\\n'\n" +
+		"      exit 0\n" +
+		"    fi\n" +
+		"  fi\n" +
+		"done\n"
+	withFakePlugin(t, "interactivetest", script)
+
+	callbacks := &fakeUI{secret: "1234"}
+	id := NewIdentity("interactivetest", "dummy-identity", callbacks)
+
+	block := &format.Recipient{Type: "whatever", Body: []byte("stanza-body")}
+	got, err := id.Unwrap(block)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(fileKey) {
+		t.Errorf("Unwrap returned %q, want %q", got, fileKey)
+	}
+	if len(callbacks.messages) != 1 || callbacks.messages[0] != "please touch your token" {
+		t.Errorf("callbacks.messages = %v, want [\"please touch your token\"]", callbacks.messages)
+	}
+}
+
+// TestIdentityUnwrapStanzaMismatch checks that a plugin reporting it doesn't
+// recognize the recipient-stanza it was sent maps to age.ErrIncorrectIdentity,
+// not a fatal error.
+func TestIdentityUnwrapStanzaMismatch(t *testing.T) {
+	script := "#!/bin/sh\n" +
+		"while IFS= read -r line; do\n" +
+		"  [ \"$line\" = \"-> done\" ] && break\n" +
+		"done\n" +
+		"printf -- '-> error recipient-stanza 0 not for me\\n'\n" +
+		"printf -- '-> done\\n'\n"
+	withFakePlugin(t, "mismatchtest", script)
+
+	id := NewIdentity("mismatchtest", "dummy-identity", nil)
+	block := &format.Recipient{Type: "whatever", Body: []byte("stanza-body")}
+	if _, err := id.Unwrap(block); err != age.ErrIncorrectIdentity {
+		t.Errorf("Unwrap = %v, want age.ErrIncorrectIdentity", err)
+	}
+}
+
+// TestIdentityUnwrapFatalError checks that a plugin error of any other kind
+// is returned as a real error, including its message text, instead of being
+// silently treated as "wrong identity".
+func TestIdentityUnwrapFatalError(t *testing.T) {
+	script := "#!/bin/sh\n" +
+		"while IFS= read -r line; do\n" +
+		"  [ \"$line\" = \"-> done\" ] && break\n" +
+		"done\n" +
+		"printf -- '-> error internal hardware token removed\\n'\n" +
+		"printf -- '-> done\\n'\n"
+	withFakePlugin(t, "fatalerrortest", script)
+
+	id := NewIdentity("fatalerrortest", "dummy-identity", nil)
+	block := &format.Recipient{Type: "whatever", Body: []byte("stanza-body")}
+	_, err := id.Unwrap(block)
+	if err == nil || err == age.ErrIncorrectIdentity {
+		t.Fatalf("Unwrap = %v, want a fatal error", err)
+	}
+	if !strings.Contains(err.Error(), "hardware token removed") {
+		t.Errorf("error = %q, want it to mention the plugin's message", err)
+	}
+}
+
+// TestRecipientWrapPluginClosesWithoutDone checks that a plugin exiting
+// mid-exchange, without ever sending "-> done", surfaces an error instead
+// of hanging or returning a nil file key.
+func TestRecipientWrapPluginClosesWithoutDone(t *testing.T) {
+	script := "#!/bin/sh\n" +
+		"printf -- '-> recipient-stanza 0 fake-stanza\\n'\n" +
+		"exit 0\n"
+	withFakePlugin(t, "nodonetest", script)
+
+	r := NewRecipient("nodonetest", "dummy-recipient")
+	_, err := r.Wrap([]byte("0123456789abcdef"))
+	if err == nil {
+		t.Fatal("Wrap succeeded against a plugin that closed without sending done, want an error")
+	}
+	if !strings.Contains(err.Error(), "done") {
+		t.Errorf("error = %q, want it to mention the missing done", err)
+	}
+}
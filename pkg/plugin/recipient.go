@@ -0,0 +1,84 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/aead/age/pkg/age"
+	"github.com/aead/age/pkg/format"
+)
+
+// Recipient is an age.Recipient backed by an external age-plugin-<name>
+// binary.
+type Recipient struct {
+	name string
+	s    string // the bech32-encoded recipient, e.g. "age1yubikey1..."
+}
+
+var _ age.Recipient = &Recipient{}
+
+// NewRecipient returns a Recipient that wraps file keys by invoking the
+// age-plugin-<name> binary on $PATH for the recipient s.
+func NewRecipient(name, s string) *Recipient {
+	return &Recipient{name: name, s: s}
+}
+
+func (r *Recipient) Type() string { return "plugin-" + r.name }
+
+func (r *Recipient) Wrap(fileKey []byte) (*format.Recipient, error) {
+	sess, err := startSession(r.name, "recipient-v1")
+	if err != nil {
+		return nil, err
+	}
+	defer sess.close()
+
+	if err := sess.send("add-recipient", r.s); err != nil {
+		return nil, fmt.Errorf("plugin %q: %v", r.name, err)
+	}
+	if err := sess.send("wrap-file-key"); err != nil {
+		return nil, fmt.Errorf("plugin %q: %v", r.name, err)
+	}
+	if err := sess.sendBody(fileKey); err != nil {
+		return nil, fmt.Errorf("plugin %q: %v", r.name, err)
+	}
+	if err := sess.done(); err != nil {
+		return nil, fmt.Errorf("plugin %q: %v", r.name, err)
+	}
+
+	msgs, err := sess.readMessages()
+	if err != nil {
+		return nil, fmt.Errorf("plugin %q: %v", r.name, err)
+	}
+	// args are "<file-key index> <stanza type> <stanza args...>". A plugin
+	// is allowed to reply with more than one recipient-stanza for a single
+	// wrap-file-key, but age.Recipient.Wrap can only return one: rather than
+	// silently keeping the first and discarding the rest, which would wrap
+	// the file key less securely than the plugin intended, treat more than
+	// one as an error this package doesn't know how to handle.
+	var stanza *format.Recipient
+	for _, m := range msgs {
+		if m.command != "recipient-stanza" {
+			return nil, fmt.Errorf("plugin %q sent unexpected message %q", r.name, m.command)
+		}
+		if len(m.args) < 2 {
+			return nil, fmt.Errorf("plugin %q sent a malformed recipient-stanza", r.name)
+		}
+		if stanza != nil {
+			return nil, fmt.Errorf("plugin %q returned more than one recipient-stanza for a single file key, which this package does not support", r.name)
+		}
+		stanza = &format.Recipient{
+			Type: m.args[1],
+			Args: m.args[2:],
+			Body: m.body,
+		}
+	}
+	if stanza == nil {
+		return nil, fmt.Errorf("plugin %q did not return a recipient stanza", r.name)
+	}
+	return stanza, nil
+}
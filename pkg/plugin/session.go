@@ -0,0 +1,151 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// Package plugin lets age.Encrypt and age.Decrypt delegate to recipients and
+// identities implemented by external age-plugin-<name> binaries found on
+// $PATH, for hardware tokens, KMS-backed keys, and other recipients that
+// can't be expressed directly in this module.
+//
+// Importing this package and calling age.RegisterPlugin registers it as the
+// handler for bech32 strings tagged for that plugin, such as
+// "age1yubikey1..." or "AGE-PLUGIN-YUBIKEY-1...".
+package plugin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/aead/age/pkg/age"
+	"github.com/aead/age/pkg/format"
+)
+
+func init() {
+	age.SetPluginFactories(
+		func(name, s string) (age.Recipient, error) { return NewRecipient(name, s), nil },
+		func(name, s string) (age.Identity, error) { return NewIdentity(name, s, defaultUI), nil },
+	)
+}
+
+// session is one run of an age-plugin-<name> binary, talking the line-based
+// protocol in which the host and the plugin exchange "-> command args..."
+// header lines, each optionally followed by one or more base64 body lines,
+// until either side sends "-> done".
+type session struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Scanner
+}
+
+// startSession spawns age-plugin-<name> with --age-plugin=<protocol>, where
+// protocol is "recipient-v1" or "identity-v1".
+func startSession(name, protocol string) (*session, error) {
+	bin := "age-plugin-" + name
+	cmd := exec.Command(bin, "--age-plugin="+protocol)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", bin, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", bin, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", bin, err)
+	}
+	return &session{cmd: cmd, stdin: stdin, stdout: bufio.NewScanner(stdout)}, nil
+}
+
+func (s *session) send(parts ...string) error {
+	_, err := io.WriteString(s.stdin, "-> "+strings.Join(parts, " ")+"\n")
+	return err
+}
+
+// bodyLineWidth is the number of base64 characters per body line, matching
+// the wrapping pkg/armor uses for its envelope body.
+const bodyLineWidth = 64
+
+// sendBody writes body as one or more base64 lines, wrapped at
+// bodyLineWidth so a long body, such as a request-secret reply, can't grow
+// into an unbounded line that a stricter plugin implementation might choke
+// on. readMessages decodes and concatenates the lines back into the body,
+// so wrapping here is transparent to the rest of the protocol.
+func (s *session) sendBody(body []byte) error {
+	encoded := format.EncodeToString(body)
+	if encoded == "" {
+		_, err := io.WriteString(s.stdin, "\n")
+		return err
+	}
+	for len(encoded) > 0 {
+		n := bodyLineWidth
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		if _, err := io.WriteString(s.stdin, encoded[:n]+"\n"); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+func (s *session) done() error {
+	return s.send("done")
+}
+
+// close tells the plugin's stdin is finished and waits for it to exit.
+func (s *session) close() error {
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
+
+// message is one "-> command args..." header line plus the base64 body
+// lines that followed it, decoded and concatenated.
+type message struct {
+	command string
+	args    []string
+	body    []byte
+}
+
+// readMessages reads header/body blocks until the plugin sends "-> done",
+// which ends the exchange and is not itself returned.
+func (s *session) readMessages() ([]message, error) {
+	var msgs []message
+	var cur *message
+	for s.stdout.Scan() {
+		line := s.stdout.Text()
+		if strings.HasPrefix(line, "-> ") {
+			if cur != nil {
+				msgs = append(msgs, *cur)
+				cur = nil
+			}
+			fields := strings.Fields(strings.TrimPrefix(line, "-> "))
+			if len(fields) == 0 {
+				return nil, errors.New("empty plugin message")
+			}
+			if fields[0] == "done" {
+				return msgs, nil
+			}
+			cur = &message{command: fields[0], args: fields[1:]}
+			continue
+		}
+		if cur == nil || line == "" {
+			continue
+		}
+		decoded, err := format.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("malformed plugin message body: %v", err)
+		}
+		cur.body = append(cur.body, decoded...)
+	}
+	if err := s.stdout.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("plugin closed the connection without sending done")
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package plugin
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aead/age/pkg/format"
+)
+
+// nopCloserBuffer adapts a bytes.Buffer to io.WriteCloser so it can stand
+// in for session.stdin without spawning a real plugin process.
+type nopCloserBuffer struct {
+	bytes.Buffer
+}
+
+func (nopCloserBuffer) Close() error { return nil }
+
+func TestSessionSendBodyWrapsLongLines(t *testing.T) {
+	buf := &nopCloserBuffer{}
+	sess := &session{stdin: buf}
+
+	body := bytes.Repeat([]byte{0x42}, 100)
+	if err := sess.sendBody(body); err != nil {
+		t.Fatalf("sendBody: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("sendBody wrote %d lines, want a 100-byte body wrapped across multiple lines", len(lines))
+	}
+
+	var decoded []byte
+	for _, line := range lines {
+		if len(line) > bodyLineWidth {
+			t.Errorf("line %q is %d characters, want at most %d", line, len(line), bodyLineWidth)
+		}
+		chunk, err := format.DecodeString(line)
+		if err != nil {
+			t.Fatalf("failed to decode line %q: %v", line, err)
+		}
+		decoded = append(decoded, chunk...)
+	}
+	if !bytes.Equal(decoded, body) {
+		t.Errorf("decoded body = %x, want %x", decoded, body)
+	}
+}
+
+func TestSessionSendBodyEmpty(t *testing.T) {
+	buf := &nopCloserBuffer{}
+	sess := &session{stdin: buf}
+	if err := sess.sendBody(nil); err != nil {
+		t.Fatalf("sendBody: %v", err)
+	}
+	if buf.String() != "\n" {
+		t.Errorf("sendBody(nil) wrote %q, want a single blank line", buf.String())
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// Package ui defines the interface age uses to talk to the person running
+// it, so that callers such as hardware-token or KMS plugins can surface
+// messages and prompt for secrets without age depending on a particular
+// terminal or GUI library.
+package ui
+
+// A UI lets a Recipient or Identity implementation, such as a plugin,
+// display informational messages and request secrets (a PIN, a touch
+// confirmation, a passphrase) from the person running age.
+type UI interface {
+	// Display shows an informational message to the user, such as a
+	// plugin asking them to touch their hardware token.
+	Display(message string) error
+
+	// RequestSecret asks the user to provide a secret described by prompt,
+	// such as a PIN, and returns what they typed.
+	RequestSecret(prompt string) (secret string, err error)
+}